@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestBatchCommitAppliesAllOps verifies a Batch's buffered Set/Delete
+// operations all land atomically: both Sets are visible and the deleted
+// record is gone after a single Commit.
+func TestBatchCommitAppliesAllOps(t *testing.T) {
+	dir := t.TempDir()
+	ds := newTestDatastore(t, dir)
+
+	if err := ds.Set("shard1_stale", map[string]interface{}{"val": "gone soon"}, "shard1"); err != nil {
+		t.Fatalf("seed Set: %v", err)
+	}
+
+	b := ds.Batch()
+	if err := b.Set("shard1_rec1", map[string]interface{}{"val": "one"}, "shard1"); err != nil {
+		t.Fatalf("batch Set: %v", err)
+	}
+	if err := b.Set("shard2_rec2", map[string]interface{}{"val": "two"}, "shard2"); err != nil {
+		t.Fatalf("batch Set: %v", err)
+	}
+	if err := b.Delete("shard1_stale"); err != nil {
+		t.Fatalf("batch Delete: %v", err)
+	}
+	if got, want := b.Len(), 3; got != want {
+		t.Fatalf("Len() = %d, want %d", got, want)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	rec1, err := ds.Get("shard1_rec1")
+	if err != nil || rec1 == nil {
+		t.Fatalf("shard1_rec1 not visible after batch commit: data=%v err=%v", rec1, err)
+	}
+	rec2, err := ds.Get("shard2_rec2")
+	if err != nil || rec2 == nil {
+		t.Fatalf("shard2_rec2 not visible after batch commit: data=%v err=%v", rec2, err)
+	}
+	stale, err := ds.Get("shard1_stale")
+	if err != nil {
+		t.Fatalf("Get shard1_stale: %v", err)
+	}
+	if stale != nil {
+		t.Fatalf("shard1_stale should have been deleted by the batch, got %v", stale)
+	}
+}
+
+// TestSaveOnlyRewritesDirtyShards verifies Save's dirtyFiles tracking: a
+// shard untouched since the last Save is left alone (mtime unchanged)
+// while a shard with a new write is rewritten.
+func TestSaveOnlyRewritesDirtyShards(t *testing.T) {
+	dir := t.TempDir()
+	ds := newTestDatastore(t, dir)
+
+	if err := ds.Set("shard1_rec1", map[string]interface{}{"val": "one"}, "shard1"); err != nil {
+		t.Fatalf("Set shard1_rec1: %v", err)
+	}
+	if err := ds.Save(); err != nil {
+		t.Fatalf("first Save: %v", err)
+	}
+
+	shard1Path := filepath.Join(dir, "records_shard1.yaml")
+	info1, err := os.Stat(shard1Path)
+	if err != nil {
+		t.Fatalf("stat shard1 after first save: %v", err)
+	}
+
+	// Ensure the next write would produce a detectably different mtime if
+	// the shard were rewritten.
+	time.Sleep(10 * time.Millisecond)
+
+	if err := ds.Set("shard2_rec2", map[string]interface{}{"val": "two"}, "shard2"); err != nil {
+		t.Fatalf("Set shard2_rec2: %v", err)
+	}
+	if err := ds.Save(); err != nil {
+		t.Fatalf("second Save: %v", err)
+	}
+
+	shard2Path := filepath.Join(dir, "records_shard2.yaml")
+	if _, err := os.Stat(shard2Path); err != nil {
+		t.Fatalf("shard2 was not written by the second Save: %v", err)
+	}
+
+	info1Again, err := os.Stat(shard1Path)
+	if err != nil {
+		t.Fatalf("stat shard1 after second save: %v", err)
+	}
+	if !info1Again.ModTime().Equal(info1.ModTime()) {
+		t.Fatalf("shard1 was rewritten by a Save that only touched shard2: mtime %v -> %v", info1.ModTime(), info1Again.ModTime())
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLDatastore manages generic YAML data in memory
+type YAMLDatastore struct {
+	mu             sync.RWMutex
+	data           map[string]interface{}     // In-memory cache: recordID -> data
+	files          map[string]bool            // Track YAML files
+	indexes        map[string]Index           // Indexes: name (keys joined with "+") -> Index
+	dir            string                     // Directory containing YAML files
+	cache          map[string]interface{}     // Cache for merged data
+	cacheLock      sync.RWMutex               // Lock for cache
+	dirty          bool                       // Flag for unsaved changes
+	saveMu         sync.Mutex                 // Lock for file writes
+	loadCount      uint64                     // Atomic counter for loaded files
+	wal            *WAL                       // Write-ahead log for crash recovery
+	version        uint64                     // Monotonic version counter for MVCC snapshots
+	history        map[string][]recordVersion // Bounded per-record version ring for snapshot reads
+	corruptedFiles []string                   // Shard paths that failed their header/CRC32 check on load
+	dirtyFiles     map[string]bool            // Shard paths touched since the last Save; Save rewrites only these
+}
+
+// NewYAMLDatastore initializes the datastore and starts auto-save
+func NewYAMLDatastore(dir string) (*YAMLDatastore, error) {
+	indexes, err := loadIndexDefs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("loading index definitions: %w", err)
+	}
+
+	ds := &YAMLDatastore{
+		data:       make(map[string]interface{}, 1000),
+		files:      make(map[string]bool, 100),
+		indexes:    indexes,
+		dir:        dir,
+		cache:      make(map[string]interface{}),
+		dirty:      false,
+		loadCount:  0,
+		history:    make(map[string][]recordVersion),
+		dirtyFiles: make(map[string]bool),
+	}
+
+	// Load all YAML files concurrently; index contents are rebuilt as part
+	// of this too, in parallel per shard (see ConcurrentRead).
+	if err := ds.ConcurrentRead(); err != nil {
+		return nil, err
+	}
+	if len(ds.corruptedFiles) > 0 && !viper.GetBool("allow_corrupted") {
+		return nil, fmt.Errorf("refusing to start: %d corrupted shard(s) detected (run `yarndb repair` or start with --allow-corrupted): %v",
+			len(ds.corruptedFiles), ds.corruptedFiles)
+	}
+	if len(ds.corruptedFiles) > 0 {
+		log.Warnf("starting with %d corrupted shard(s) ignored due to --allow-corrupted: %v", len(ds.corruptedFiles), ds.corruptedFiles)
+	}
+
+	// Open the WAL and replay any records written since the last checkpoint,
+	// so mutations made after the last snapshot survive a crash.
+	lastCheckpoint, err := readWALCheckpoint(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading WAL checkpoint: %w", err)
+	}
+	wal, err := newWAL(dir)
+	if err != nil {
+		return nil, err
+	}
+	ds.wal = wal
+
+	ds.mu.Lock()
+	applied, err := ds.replayWAL(lastCheckpoint)
+	ds.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if applied > 0 {
+		// A crash partway through a save can leave any shard stale, so play
+		// it safe and mark every shard dirty rather than trying to track
+		// exactly which ones the replayed frames touched.
+		ds.dirty = true
+		for path := range ds.files {
+			ds.dirtyFiles[path] = true
+		}
+	}
+
+	// Start auto-save goroutine
+	go ds.autoSave()
+
+	// Handle OS signals for graceful shutdown
+	go ds.handleSignals()
+
+	return ds, nil
+}
+
+// Set creates or updates a record. The WAL group is appended while holding
+// saveMu, which also serializes Set against Transaction.Commit so version
+// numbers and WAL order always agree.
+func (ds *YAMLDatastore) Set(recordID string, data interface{}, fileID string) error {
+	payload, err := yaml.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("encoding record %s for WAL: %w", recordID, err)
+	}
+
+	ds.saveMu.Lock()
+	defer ds.saveMu.Unlock()
+	if _, err := ds.wal.AppendGroup([]walEntry{{op: walOpSet, recordID: recordID, fileID: fileID, payload: payload}}); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	path := filepath.Join(ds.dir, "records_"+fileID+".yaml")
+	ds.data[recordID] = data
+	ds.files[path] = true
+	ds.dirty = true
+	ds.dirtyFiles[path] = true
+	ds.invalidateCache()
+	ds.updateIndexes(recordID, data)
+	ds.pushHistory(recordID, ds.nextVersion(), data)
+	log.Infof("Set record %s in file %s", recordID, fileID)
+	return nil
+}
+
+// Get retrieves the current value of a record by ID.
+func (ds *YAMLDatastore) Get(recordID string) (interface{}, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	data, exists := ds.data[recordID]
+	if !exists {
+		return nil, nil
+	}
+	log.Debugf("Retrieved record %s", recordID)
+	return data, nil
+}
+
+// Delete removes a record.
+func (ds *YAMLDatastore) Delete(recordID string) error {
+	ds.mu.RLock()
+	_, exists := ds.data[recordID]
+	ds.mu.RUnlock()
+	if !exists {
+		return errors.New("record not found")
+	}
+
+	ds.saveMu.Lock()
+	defer ds.saveMu.Unlock()
+	if _, err := ds.wal.AppendGroup([]walEntry{{op: walOpDelete, recordID: recordID}}); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.dirtyFiles[ds.shardPathFor(recordID)] = true
+	delete(ds.data, recordID)
+	ds.dirty = true
+	ds.invalidateCache()
+	ds.removeFromIndexes(recordID)
+	ds.pushHistory(recordID, ds.nextVersion(), nil)
+	log.Infof("Deleted record %s", recordID)
+	return nil
+}
+
+// Query finds records matching a key=value condition against the current
+// (not snapshotted) state. Use a Snapshot for a point-in-time view.
+func (ds *YAMLDatastore) Query(key, value string) (map[string]interface{}, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	result := make(map[string]interface{})
+	if idx, exists := ds.indexes[key]; exists {
+		for _, id := range idx.Query(value) {
+			if data, ok := ds.data[id]; ok {
+				result[id] = data
+			}
+		}
+	} else {
+		// Scan all records
+		for id, data := range ds.data {
+			if val, ok := getNestedValue(data, key); ok && fmt.Sprintf("%v", val) == value {
+				result[id] = data
+			}
+		}
+	}
+	log.Debugf("Queried %d records for %s=%s", len(result), key, value)
+	return result, nil
+}
+
+// QueryRange finds records whose value for key, which must have a range
+// (ordered) index, falls within [lo, hi] (inclusive).
+func (ds *YAMLDatastore) QueryRange(key, lo, hi string) (map[string]interface{}, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	idx, ok := ds.indexes[key].(RangeIndex)
+	if !ok {
+		return nil, fmt.Errorf("no range index on %s", key)
+	}
+	result := make(map[string]interface{})
+	for _, id := range idx.QueryRange(lo, hi) {
+		if data, ok := ds.data[id]; ok {
+			result[id] = data
+		}
+	}
+	log.Debugf("Range-queried %d records for %s in [%s, %s]", len(result), key, lo, hi)
+	return result, nil
+}
+
+// QueryPrefix finds records whose value for key, which must have a range
+// (ordered) index, starts with prefix.
+func (ds *YAMLDatastore) QueryPrefix(key, prefix string) (map[string]interface{}, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	idx, ok := ds.indexes[key].(RangeIndex)
+	if !ok {
+		return nil, fmt.Errorf("no range index on %s", key)
+	}
+	result := make(map[string]interface{})
+	for _, id := range idx.QueryPrefix(prefix) {
+		if data, ok := ds.data[id]; ok {
+			result[id] = data
+		}
+	}
+	log.Debugf("Prefix-queried %d records for %s starting with %q", len(result), key, prefix)
+	return result, nil
+}
+
+// QueryComposite finds records matching every key=value pair in keys/values
+// (same length, same order) against a composite index built on exactly
+// those keys by CreateCompositeIndex. Use CompositeIndexValue to build the
+// canonical value yourself if you're querying an Index directly.
+func (ds *YAMLDatastore) QueryComposite(keys []string, values []string) (map[string]interface{}, error) {
+	if len(keys) != len(values) {
+		return nil, errors.New("keys and values must be the same length")
+	}
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	idx, exists := ds.indexes[strings.Join(keys, "+")]
+	if !exists {
+		return nil, fmt.Errorf("no composite index on %v", keys)
+	}
+	result := make(map[string]interface{})
+	for _, id := range idx.Query(CompositeIndexValue(values...)) {
+		if data, ok := ds.data[id]; ok {
+			result[id] = data
+		}
+	}
+	log.Debugf("Composite-queried %d records for %v", len(result), keys)
+	return result, nil
+}
+
+// CreateIndex builds a hash (equality, non-unique) index on a single
+// top-level key.
+func (ds *YAMLDatastore) CreateIndex(key string) error {
+	return ds.createIndex(newHashIndex(key))
+}
+
+// CreateRangeIndex builds an ordered index on a single top-level key,
+// supporting QueryRange and QueryPrefix in addition to equality.
+func (ds *YAMLDatastore) CreateRangeIndex(key string) error {
+	return ds.createIndex(newOrderedIndex(key))
+}
+
+// CreateCompositeIndex builds a hash index over several keys at once, so a
+// multi-field equality query (department=eng AND level=senior) hits one
+// index instead of intersecting separate ones. Keys are canonicalized and
+// concatenated with compositeKeySep.
+func (ds *YAMLDatastore) CreateCompositeIndex(keys ...string) error {
+	if len(keys) < 2 {
+		return errors.New("composite index needs at least two keys")
+	}
+	return ds.createIndex(newHashIndex(keys...))
+}
+
+// createIndex registers idx, backfills it from the current data, and
+// persists the updated set of index definitions to indexes.yaml.
+func (ds *YAMLDatastore) createIndex(idx Index) error {
+	ds.mu.Lock()
+	if _, exists := ds.indexes[idx.Name()]; exists {
+		ds.mu.Unlock()
+		return errors.New("index already exists")
+	}
+	for id, data := range ds.data {
+		idx.Put(id, data)
+	}
+	ds.indexes[idx.Name()] = idx
+	defs := make(map[string]Index, len(ds.indexes))
+	for name, existing := range ds.indexes {
+		defs[name] = existing
+	}
+	ds.mu.Unlock()
+
+	if err := saveIndexDefs(ds.dir, defs); err != nil {
+		return fmt.Errorf("persisting index definitions: %w", err)
+	}
+	log.Infof("Created %s index on %v", idx.Kind(), idx.Keys())
+	return nil
+}
+
+// Merge combines all records as they currently stand. Use a Snapshot for a
+// point-in-time view.
+func (ds *YAMLDatastore) Merge() (map[string]interface{}, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	ds.cacheLock.RLock()
+	if cached, ok := ds.cache["merged"].(map[string]interface{}); ok {
+		ds.cacheLock.RUnlock()
+		return cached, nil
+	}
+	ds.cacheLock.RUnlock()
+
+	merged := make(map[string]interface{}, len(ds.data))
+	for id, data := range ds.data {
+		merged[id] = data
+	}
+
+	ds.cacheLock.Lock()
+	ds.cache["merged"] = merged
+	ds.cacheLock.Unlock()
+	log.Debugf("Merged %d records", len(merged))
+	return merged, nil
+}
+
+// invalidateCache clears the merge cache
+func (ds *YAMLDatastore) invalidateCache() {
+	ds.cacheLock.Lock()
+	ds.cache = make(map[string]interface{})
+	ds.cacheLock.Unlock()
+}
+
+// updateIndexes pushes recordID's current value into every index. Each
+// Index is internally synchronized, so this may be called concurrently for
+// different records (ConcurrentRead does, one goroutine per shard).
+func (ds *YAMLDatastore) updateIndexes(recordID string, data interface{}) {
+	for _, idx := range ds.indexes {
+		idx.Put(recordID, data)
+	}
+}
+
+// removeFromIndexes drops recordID from every index.
+func (ds *YAMLDatastore) removeFromIndexes(recordID string) {
+	for _, idx := range ds.indexes {
+		idx.Remove(recordID)
+	}
+}
+
+// shardPathFor returns the shard file recordID belongs to, derived the same
+// way Save groups records: the recordID's prefix up to the first
+// underscore, falling back to the default shard if that derived path was
+// never written to. Callers must hold ds.mu.
+func (ds *YAMLDatastore) shardPathFor(recordID string) string {
+	fileID := strings.Split(recordID, "_")[0]
+	path := filepath.Join(ds.dir, "records_"+fileID+".yaml")
+	if _, exists := ds.files[path]; exists {
+		return path
+	}
+	return filepath.Join(ds.dir, "records_default.yaml")
+}
+
+// getNestedValue retrieves a nested value by dot-separated key
+func getNestedValue(data interface{}, key string) (interface{}, bool) {
+	keys := strings.Split(key, ".")
+	current := data
+	for _, k := range keys {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			var ok bool
+			current, ok = v[k]
+			if !ok {
+				return nil, false
+			}
+		case map[interface{}]interface{}:
+			var ok bool
+			current, ok = v[k]
+			if !ok {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// autoSave runs periodic saves
+func (ds *YAMLDatastore) autoSave() {
+	interval := time.Duration(viper.GetInt("auto_save_interval")) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := ds.Save(); err != nil {
+			log.Errorf("Auto-save error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CorruptedError marks an error as a shard integrity failure (a bad
+// header/CRC32, a torn write) rather than an ordinary I/O or YAML-parsing
+// problem, so callers can branch on it via IsCorrupted.
+type CorruptedError struct {
+	Path string
+	Err  error
+}
+
+func (e *CorruptedError) Error() string {
+	return fmt.Sprintf("corrupted shard %s: %v", e.Path, e.Err)
+}
+
+func (e *CorruptedError) Unwrap() error { return e.Err }
+
+// IsCorrupted reports whether err (or any error it wraps) is a
+// *CorruptedError, mirroring goleveldb's errors.IsCorrupted.
+func IsCorrupted(err error) bool {
+	var ce *CorruptedError
+	return errors.As(err, &ce)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// indexDefsFileName holds index definitions (which keys, which kind), never
+// contents; contents are always rebuilt from ds.data on startup. Excluded
+// from shard loading in ConcurrentRead/repair since it isn't a shard.
+const indexDefsFileName = "indexes.yaml"
+
+// compositeKeySep separates canonicalized per-key values inside a composite
+// index's key, chosen to be unlikely to appear in an actual field value.
+const compositeKeySep = "\x1f"
+
+// Index is a secondary index over one or more record fields. Implementations
+// are internally synchronized, so Put/Remove/Query may be called
+// concurrently (ConcurrentRead rebuilds index contents with one goroutine
+// per shard, all pushing into the same Index instances at once).
+type Index interface {
+	// Name identifies the index in ds.indexes and indexes.yaml: its keys
+	// joined with "+".
+	Name() string
+	// Keys returns the field(s) the index is built on, in order.
+	Keys() []string
+	// Kind reports the implementation, for persistence ("hash" or "ordered").
+	Kind() string
+	// Put indexes recordID's current value, replacing any previous entry.
+	Put(recordID string, data interface{})
+	// Remove drops recordID from the index.
+	Remove(recordID string)
+	// Query returns every recordID whose indexed value equals value.
+	Query(value string) []string
+}
+
+// RangeIndex is an Index that additionally supports ordered range and
+// prefix scans. Only orderedIndex implements it.
+type RangeIndex interface {
+	Index
+	QueryRange(lo, hi string) []string
+	QueryPrefix(prefix string) []string
+}
+
+// indexValue canonicalizes the value(s) of keys (one for a plain index,
+// several for a composite index) into the single string an Index is keyed
+// by. Returns false if any key is missing from data.
+func indexValue(data interface{}, keys []string) (string, bool) {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		val, ok := getNestedValue(data, key)
+		if !ok {
+			return "", false
+		}
+		parts[i] = fmt.Sprintf("%v", val)
+	}
+	return strings.Join(parts, compositeKeySep), true
+}
+
+// CompositeIndexValue canonicalizes already-stringified field values, given
+// in the same order as the keys a composite index was created with, into
+// the key that index's Query expects. It's the query-side counterpart to
+// indexValue, which does the same join starting from a record's raw field
+// values instead of caller-supplied strings.
+func CompositeIndexValue(values ...string) string {
+	return strings.Join(values, compositeKeySep)
+}
+
+// hashIndex is an equality-only, non-unique secondary index: a canonical
+// value maps to every recordID holding it, so (unlike the old
+// map[interface{}]string) records sharing a value no longer clobber one
+// another.
+type hashIndex struct {
+	mu       sync.Mutex
+	keys     []string
+	vals     map[string][]string // canonical value -> recordIDs
+	byRecord map[string]string   // recordID -> canonical value, for O(1) Remove
+}
+
+func newHashIndex(keys ...string) *hashIndex {
+	return &hashIndex{
+		keys:     keys,
+		vals:     make(map[string][]string),
+		byRecord: make(map[string]string),
+	}
+}
+
+func (h *hashIndex) Name() string   { return strings.Join(h.keys, "+") }
+func (h *hashIndex) Keys() []string { return h.keys }
+func (h *hashIndex) Kind() string   { return "hash" }
+
+func (h *hashIndex) Put(recordID string, data interface{}) {
+	val, ok := indexValue(data, h.keys)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !ok {
+		h.removeLocked(recordID)
+		return
+	}
+	if old, exists := h.byRecord[recordID]; exists {
+		if old == val {
+			return
+		}
+		h.removeLocked(recordID)
+	}
+	h.vals[val] = append(h.vals[val], recordID)
+	h.byRecord[recordID] = val
+}
+
+func (h *hashIndex) Remove(recordID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(recordID)
+}
+
+func (h *hashIndex) removeLocked(recordID string) {
+	val, exists := h.byRecord[recordID]
+	if !exists {
+		return
+	}
+	ids := h.vals[val]
+	for i, id := range ids {
+		if id == recordID {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(h.vals, val)
+	} else {
+		h.vals[val] = ids
+	}
+	delete(h.byRecord, recordID)
+}
+
+func (h *hashIndex) Query(value string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ids := h.vals[value]
+	out := make([]string, len(ids))
+	copy(out, ids)
+	return out
+}
+
+// orderedEntry is one distinct value in an orderedIndex, in sorted position.
+type orderedEntry struct {
+	key string
+	ids []string
+}
+
+// orderedIndex is a sorted, non-unique secondary index keyed by the
+// canonical string form of its value(s), supporting range and prefix scans
+// in addition to equality. It's backed by a sorted slice searched with
+// binary search rather than a B-tree/skiplist, since this codebase doesn't
+// vendor one; Put/Remove are O(log n) to locate plus O(n) to shift the
+// slice, which is fine at the record counts yarndb targets.
+type orderedIndex struct {
+	mu       sync.Mutex
+	keys     []string
+	entries  []orderedEntry
+	byRecord map[string]string
+}
+
+func newOrderedIndex(keys ...string) *orderedIndex {
+	return &orderedIndex{keys: keys, byRecord: make(map[string]string)}
+}
+
+func (o *orderedIndex) Name() string   { return strings.Join(o.keys, "+") }
+func (o *orderedIndex) Keys() []string { return o.keys }
+func (o *orderedIndex) Kind() string   { return "ordered" }
+
+// search returns the index of the first entry with key >= target (the
+// position target would be inserted at to keep entries sorted). Callers
+// must hold o.mu.
+func (o *orderedIndex) search(target string) int {
+	return sort.Search(len(o.entries), func(i int) bool { return o.entries[i].key >= target })
+}
+
+func (o *orderedIndex) Put(recordID string, data interface{}) {
+	val, ok := indexValue(data, o.keys)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !ok {
+		o.removeLocked(recordID)
+		return
+	}
+	if old, exists := o.byRecord[recordID]; exists {
+		if old == val {
+			return
+		}
+		o.removeLocked(recordID)
+	}
+	i := o.search(val)
+	if i < len(o.entries) && o.entries[i].key == val {
+		o.entries[i].ids = append(o.entries[i].ids, recordID)
+	} else {
+		o.entries = append(o.entries, orderedEntry{})
+		copy(o.entries[i+1:], o.entries[i:])
+		o.entries[i] = orderedEntry{key: val, ids: []string{recordID}}
+	}
+	o.byRecord[recordID] = val
+}
+
+func (o *orderedIndex) Remove(recordID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.removeLocked(recordID)
+}
+
+func (o *orderedIndex) removeLocked(recordID string) {
+	val, exists := o.byRecord[recordID]
+	if !exists {
+		return
+	}
+	i := o.search(val)
+	if i < len(o.entries) && o.entries[i].key == val {
+		ids := o.entries[i].ids
+		for j, id := range ids {
+			if id == recordID {
+				ids = append(ids[:j], ids[j+1:]...)
+				break
+			}
+		}
+		if len(ids) == 0 {
+			o.entries = append(o.entries[:i], o.entries[i+1:]...)
+		} else {
+			o.entries[i].ids = ids
+		}
+	}
+	delete(o.byRecord, recordID)
+}
+
+func (o *orderedIndex) Query(value string) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	i := o.search(value)
+	if i >= len(o.entries) || o.entries[i].key != value {
+		return nil
+	}
+	out := make([]string, len(o.entries[i].ids))
+	copy(out, o.entries[i].ids)
+	return out
+}
+
+// QueryRange returns every recordID whose indexed value is within [lo, hi]
+// (inclusive), in key order.
+func (o *orderedIndex) QueryRange(lo, hi string) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var out []string
+	for i := o.search(lo); i < len(o.entries) && o.entries[i].key <= hi; i++ {
+		out = append(out, o.entries[i].ids...)
+	}
+	return out
+}
+
+// QueryPrefix returns every recordID whose indexed value starts with
+// prefix, in key order.
+func (o *orderedIndex) QueryPrefix(prefix string) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var out []string
+	for i := o.search(prefix); i < len(o.entries) && strings.HasPrefix(o.entries[i].key, prefix); i++ {
+		out = append(out, o.entries[i].ids...)
+	}
+	return out
+}
+
+// indexDef is a persisted description of one index: which keys it covers
+// and which implementation backs it. Only the definition is persisted;
+// indexes.yaml has no content, since every index is rebuilt from ds.data by
+// ConcurrentRead on startup.
+type indexDef struct {
+	Keys []string `yaml:"keys"`
+	Kind string   `yaml:"kind"`
+}
+
+func indexDefsPath(dir string) string { return filepath.Join(dir, indexDefsFileName) }
+
+func newIndex(def indexDef) (Index, error) {
+	switch def.Kind {
+	case "hash":
+		return newHashIndex(def.Keys...), nil
+	case "ordered":
+		return newOrderedIndex(def.Keys...), nil
+	default:
+		return nil, fmt.Errorf("unknown index kind %q", def.Kind)
+	}
+}
+
+// loadIndexDefs reads indexes.yaml (if present) and returns empty Index
+// instances for each definition, ready for ConcurrentRead to populate.
+func loadIndexDefs(dir string) (map[string]Index, error) {
+	indexes := make(map[string]Index)
+	b, err := os.ReadFile(indexDefsPath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return indexes, nil
+		}
+		return nil, err
+	}
+	var defs map[string]indexDef
+	if err := yaml.Unmarshal(b, &defs); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", indexDefsFileName, err)
+	}
+	for name, def := range defs {
+		idx, err := newIndex(def)
+		if err != nil {
+			return nil, fmt.Errorf("index %s: %w", name, err)
+		}
+		indexes[name] = idx
+	}
+	return indexes, nil
+}
+
+// saveIndexDefs persists the current set of index definitions to
+// indexes.yaml, overwriting it.
+func saveIndexDefs(dir string, indexes map[string]Index) error {
+	defs := make(map[string]indexDef, len(indexes))
+	for name, idx := range indexes {
+		defs[name] = indexDef{Keys: idx.Keys(), Kind: idx.Kind()}
+	}
+	out, err := yaml.Marshal(defs)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(indexDefsPath(dir), out, 0644)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+// idSet returns the sorted keys of a records map, for order-independent
+// comparison.
+func idSet(records map[string]interface{}) []string {
+	ids := make([]string, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// TestHashIndexReturnsEveryRecordForANonUniqueValue reproduces the exact
+// bug this request was written to fix: the old map[interface{}]string
+// index silently lost all but one recordID per value, so Query on a
+// shared value (e.g. department=eng) returned wrong results.
+func TestHashIndexReturnsEveryRecordForANonUniqueValue(t *testing.T) {
+	dir := t.TempDir()
+	ds := newTestDatastore(t, dir)
+
+	if err := ds.CreateIndex("department"); err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+	if err := ds.Set("shard1_alice", map[string]interface{}{"department": "eng"}, "shard1"); err != nil {
+		t.Fatalf("Set alice: %v", err)
+	}
+	if err := ds.Set("shard1_bob", map[string]interface{}{"department": "eng"}, "shard1"); err != nil {
+		t.Fatalf("Set bob: %v", err)
+	}
+	if err := ds.Set("shard1_carol", map[string]interface{}{"department": "sales"}, "shard1"); err != nil {
+		t.Fatalf("Set carol: %v", err)
+	}
+
+	records, err := ds.Query("department", "eng")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	got := idSet(records)
+	want := []string{"shard1_alice", "shard1_bob"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("Query(department=eng) = %v, want %v (both records sharing the value)", got, want)
+	}
+}
+
+// TestOrderedIndexRangeAndPrefixQueries covers QueryRange and QueryPrefix,
+// the range-scan capability a plain hash index can't provide.
+func TestOrderedIndexRangeAndPrefixQueries(t *testing.T) {
+	dir := t.TempDir()
+	ds := newTestDatastore(t, dir)
+
+	if err := ds.CreateRangeIndex("level"); err != nil {
+		t.Fatalf("CreateRangeIndex: %v", err)
+	}
+	levels := map[string]string{
+		"shard1_a": "100",
+		"shard1_b": "200",
+		"shard1_c": "300",
+		"shard1_d": "400",
+	}
+	for id, level := range levels {
+		if err := ds.Set(id, map[string]interface{}{"level": level}, "shard1"); err != nil {
+			t.Fatalf("Set %s: %v", id, err)
+		}
+	}
+
+	rangeResult, err := ds.QueryRange("level", "200", "300")
+	if err != nil {
+		t.Fatalf("QueryRange: %v", err)
+	}
+	if got, want := idSet(rangeResult), []string{"shard1_b", "shard1_c"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("QueryRange(200,300) = %v, want %v", got, want)
+	}
+
+	prefixResult, err := ds.QueryPrefix("level", "3")
+	if err != nil {
+		t.Fatalf("QueryPrefix: %v", err)
+	}
+	if got, want := idSet(prefixResult), []string{"shard1_c"}; len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("QueryPrefix(3) = %v, want %v", got, want)
+	}
+}
+
+// TestCompositeIndexQuery covers CreateCompositeIndex/QueryComposite: a
+// multi-field equality query must hit the one composite index instead of
+// requiring the caller to intersect separate per-field indexes.
+func TestCompositeIndexQuery(t *testing.T) {
+	dir := t.TempDir()
+	ds := newTestDatastore(t, dir)
+
+	if err := ds.CreateCompositeIndex("department", "level"); err != nil {
+		t.Fatalf("CreateCompositeIndex: %v", err)
+	}
+	if err := ds.Set("shard1_a", map[string]interface{}{"department": "eng", "level": "senior"}, "shard1"); err != nil {
+		t.Fatalf("Set a: %v", err)
+	}
+	if err := ds.Set("shard1_b", map[string]interface{}{"department": "eng", "level": "junior"}, "shard1"); err != nil {
+		t.Fatalf("Set b: %v", err)
+	}
+	if err := ds.Set("shard1_c", map[string]interface{}{"department": "sales", "level": "senior"}, "shard1"); err != nil {
+		t.Fatalf("Set c: %v", err)
+	}
+
+	records, err := ds.QueryComposite([]string{"department", "level"}, []string{"eng", "senior"})
+	if err != nil {
+		t.Fatalf("QueryComposite: %v", err)
+	}
+	if got, want := idSet(records), []string{"shard1_a"}; len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("QueryComposite(department=eng, level=senior) = %v, want %v", got, want)
+	}
+}
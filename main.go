@@ -7,6 +7,7 @@ import (
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v3"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 )
@@ -14,6 +15,7 @@ import (
 var (
 	log *logrus.Logger
 	ds  *YAMLDatastore
+	srv *Server
 )
 
 const asciiArt = `
@@ -64,6 +66,10 @@ func init() {
 	viper.SetDefault("data_dir", "data")
 	viper.SetDefault("auto_save_interval", 60)
 	viper.SetDefault("log_level", "info")
+	viper.SetDefault("wal_sync", "always")
+	viper.SetDefault("listen_addr", ":7331")
+	viper.SetDefault("read_timeout", "30s")
+	viper.SetDefault("allow_corrupted", false)
 	if err := viper.ReadInConfig(); err != nil {
 		log.Warnf("No config file found, using defaults: %v", err)
 	}
@@ -101,9 +107,11 @@ func main() {
 	rootCmd.PersistentFlags().String("data-dir", viper.GetString("data_dir"), "Directory for YAML files")
 	rootCmd.PersistentFlags().Int("auto-save-interval", viper.GetInt("auto_save_interval"), "Auto-save interval in seconds")
 	rootCmd.PersistentFlags().String("log-level", viper.GetString("log_level"), "Log level (debug, info, warn, error)")
+	rootCmd.PersistentFlags().Bool("allow-corrupted", viper.GetBool("allow_corrupted"), "Start even if corrupted shards are detected, instead of refusing")
 	viper.BindPFlag("data_dir", rootCmd.PersistentFlags().Lookup("data-dir"))
 	viper.BindPFlag("auto_save_interval", rootCmd.PersistentFlags().Lookup("auto-save-interval"))
 	viper.BindPFlag("log_level", rootCmd.PersistentFlags().Lookup("log-level"))
+	viper.BindPFlag("allow_corrupted", rootCmd.PersistentFlags().Lookup("allow-corrupted"))
 
 	// Init command
 	rootCmd.AddCommand(&cobra.Command{
@@ -240,7 +248,7 @@ func main() {
 	// Index command
 	rootCmd.AddCommand(&cobra.Command{
 		Use:   "index <key>",
-		Short: "Create an index on a top-level key for faster queries",
+		Short: "Create a hash index on a top-level key for faster equality queries",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			key := args[0]
@@ -260,6 +268,139 @@ func main() {
 		},
 	})
 
+	// Range index command
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "range-index <key>",
+		Short: "Create an ordered index on a top-level key, supporting query-range and query-prefix",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			key := args[0]
+			if !isValidKey(key) {
+				log.Error("Invalid key: must be alphanumeric with dots")
+				fmt.Println("Error: key must be alphanumeric with dots")
+				return
+			}
+			if err := ds.CreateRangeIndex(key); err != nil {
+				log.Errorf("Failed to create range index: %v", err)
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			log.Infof("Created range index on key %s", key)
+			fmt.Printf("Range index created on %s\n", key)
+		},
+	})
+
+	// Composite index command
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "composite-index <key1> <key2> [key...]",
+		Short: "Create a hash index over several keys so multi-field equality queries hit one index",
+		Args:  cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, key := range args {
+				if !isValidKey(key) {
+					log.Error("Invalid key: must be alphanumeric with dots")
+					fmt.Println("Error: key must be alphanumeric with dots")
+					return
+				}
+			}
+			if err := ds.CreateCompositeIndex(args...); err != nil {
+				log.Errorf("Failed to create composite index: %v", err)
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			log.Infof("Created composite index on keys %v", args)
+			fmt.Printf("Composite index created on %v\n", args)
+		},
+	})
+
+	// Range query command
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "query-range <key> <lo> <hi>",
+		Short: "Query records whose value for key (a range-indexed field) falls within [lo, hi]",
+		Args:  cobra.ExactArgs(3),
+		Run: func(cmd *cobra.Command, args []string) {
+			key, lo, hi := args[0], args[1], args[2]
+			if !isValidKey(key) {
+				log.Error("Invalid key: must be alphanumeric with dots")
+				fmt.Println("Error: key must be alphanumeric with dots")
+				return
+			}
+			records, err := ds.QueryRange(key, lo, hi)
+			if err != nil {
+				log.Errorf("Range query failed: %v", err)
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			fmt.Printf("Found %d records:\n", len(records))
+			for id, data := range records {
+				out, _ := yaml.Marshal(data)
+				fmt.Printf("- %s:\n%s\n", id, string(out))
+			}
+		},
+	})
+
+	// Prefix query command
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "query-prefix <key> <prefix>",
+		Short: "Query records whose value for key (a range-indexed field) starts with prefix",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			key, prefix := args[0], args[1]
+			if !isValidKey(key) {
+				log.Error("Invalid key: must be alphanumeric with dots")
+				fmt.Println("Error: key must be alphanumeric with dots")
+				return
+			}
+			records, err := ds.QueryPrefix(key, prefix)
+			if err != nil {
+				log.Errorf("Prefix query failed: %v", err)
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			fmt.Printf("Found %d records:\n", len(records))
+			for id, data := range records {
+				out, _ := yaml.Marshal(data)
+				fmt.Printf("- %s:\n%s\n", id, string(out))
+			}
+		},
+	})
+
+	// Composite query command
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "query-composite <key1=value1> <key2=value2> [key=value...]",
+		Short: "Query records matching every key=value pair against a composite index built on those keys",
+		Args:  cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			keys := make([]string, len(args))
+			values := make([]string, len(args))
+			for i, expr := range args {
+				parts := strings.SplitN(expr, "=", 2)
+				if len(parts) != 2 {
+					log.Error("Invalid query expression: must be key=value")
+					fmt.Println("Error: query expression must be key=value")
+					return
+				}
+				if !isValidKey(parts[0]) {
+					log.Error("Invalid key: must be alphanumeric with dots")
+					fmt.Println("Error: key must be alphanumeric with dots")
+					return
+				}
+				keys[i], values[i] = parts[0], parts[1]
+			}
+			records, err := ds.QueryComposite(keys, values)
+			if err != nil {
+				log.Errorf("Composite query failed: %v", err)
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			fmt.Printf("Found %d records:\n", len(records))
+			for id, data := range records {
+				out, _ := yaml.Marshal(data)
+				fmt.Printf("- %s:\n%s\n", id, string(out))
+			}
+		},
+	})
+
 	// Transaction command
 	rootCmd.AddCommand(&cobra.Command{
 		Use:   "trans",
@@ -367,6 +508,159 @@ func main() {
 		},
 	})
 
+	// Serve command
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "serve",
+		Short: "Serve YarnDB over TCP so clients don't need to spawn a CLI per operation",
+		Run: func(cmd *cobra.Command, args []string) {
+			srv = NewServer(ds)
+			if err := srv.Start(); err != nil {
+				log.Fatalf("Failed to start server: %v", err)
+			}
+			fmt.Printf("YarnDB serving line protocol on %s\n", viper.GetString("listen_addr"))
+			if binAddr := viper.GetString("bin_listen_addr"); binAddr != "" {
+				fmt.Printf("YarnDB serving binary protocol on %s\n", binAddr)
+			}
+			select {} // handleSignals (started in NewYAMLDatastore) handles shutdown
+		},
+	})
+	rootCmd.PersistentFlags().String("bin-listen-addr", viper.GetString("bin_listen_addr"), "Address for the length-prefixed binary protocol (empty disables it)")
+	rootCmd.PersistentFlags().String("listen-addr", viper.GetString("listen_addr"), "Address for the line protocol TCP server")
+	rootCmd.PersistentFlags().String("read-timeout", viper.GetString("read_timeout"), "Per-connection read timeout (e.g. 30s)")
+	viper.BindPFlag("bin_listen_addr", rootCmd.PersistentFlags().Lookup("bin-listen-addr"))
+	viper.BindPFlag("listen_addr", rootCmd.PersistentFlags().Lookup("listen-addr"))
+	viper.BindPFlag("read_timeout", rootCmd.PersistentFlags().Lookup("read-timeout"))
+
+	// Repair command
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "repair",
+		Short: "Quarantine corrupted shards into lost+found and recover their records from the WAL",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// Deliberately skip the root's PersistentPreRun: NewYAMLDatastore
+			// refuses to start on corruption (see --allow-corrupted), which
+			// is exactly the situation repair exists to fix.
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			dataDir := viper.GetString("data_dir")
+			lostFound := filepath.Join(dataDir, lostFoundDirName)
+
+			var quarantined []string
+			affectedFileIDs := make(map[string]bool)
+			err := filepath.Walk(dataDir, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() {
+					if info.Name() == lostFoundDirName {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !strings.HasSuffix(path, ".yaml") || info.Name() == indexDefsFileName {
+					return nil
+				}
+				if _, err := validateShardFile(path); err != nil {
+					if !IsCorrupted(err) {
+						return err
+					}
+					quarantined = append(quarantined, path)
+					affectedFileIDs[shardFileID(path)] = true
+				}
+				return nil
+			})
+			if err != nil {
+				log.Errorf("repair: walking %s: %v", dataDir, err)
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			if len(quarantined) == 0 {
+				fmt.Println("No corrupted shards found")
+				return
+			}
+
+			if err := os.MkdirAll(lostFound, 0755); err != nil {
+				log.Errorf("repair: creating %s: %v", lostFound, err)
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			for _, path := range quarantined {
+				dest := filepath.Join(lostFound, filepath.Base(path))
+				if err := os.Rename(path, dest); err != nil {
+					log.Errorf("repair: quarantining %s: %v", path, err)
+					fmt.Printf("Error: %v\n", err)
+					return
+				}
+				log.Warnf("repair: quarantined corrupted shard %s -> %s", path, dest)
+			}
+
+			lastCheckpoint, err := readWALCheckpoint(dataDir)
+			if err != nil && !os.IsNotExist(err) {
+				log.Errorf("repair: reading WAL checkpoint: %v", err)
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			frames, err := committedWALFrames(walPath(dataDir), lastCheckpoint)
+			if err != nil {
+				log.Errorf("repair: replaying WAL: %v", err)
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+
+			// Reconstruct only the shards we just quarantined, from WAL
+			// groups committed since the last checkpoint. Deletes don't
+			// carry a fileID, so they're applied across every bucket being
+			// reconstructed rather than filtered by fileID.
+			recovered := make(map[string]map[string]interface{})
+			for _, fr := range frames {
+				switch fr.op {
+				case walOpSet:
+					if !affectedFileIDs[fr.fileID] {
+						continue
+					}
+					var data interface{}
+					if err := yaml.Unmarshal(fr.payload, &data); err != nil {
+						log.Warnf("repair: skipping unreadable record %s: %v", fr.recordID, err)
+						continue
+					}
+					path := filepath.Join(dataDir, "records_"+fr.fileID+".yaml")
+					if recovered[path] == nil {
+						recovered[path] = make(map[string]interface{})
+					}
+					recovered[path][fr.recordID] = data
+				case walOpDelete:
+					for _, bucket := range recovered {
+						delete(bucket, fr.recordID)
+					}
+				}
+			}
+
+			recoveredCount := 0
+			for path, records := range recovered {
+				if len(records) == 0 {
+					continue
+				}
+				out, err := yaml.Marshal(records)
+				if err != nil {
+					log.Errorf("repair: encoding %s: %v", path, err)
+					fmt.Printf("Error: %v\n", err)
+					return
+				}
+				framed := append([]byte(shardHeader(out)), out...)
+				if err := os.WriteFile(path, framed, 0644); err != nil {
+					log.Errorf("repair: writing %s: %v", path, err)
+					fmt.Printf("Error: %v\n", err)
+					return
+				}
+				recoveredCount += len(records)
+			}
+
+			log.Infof("repair: quarantined %d shard(s), recovered %d record(s) from the WAL", len(quarantined), recoveredCount)
+			fmt.Printf("Quarantined %d corrupted shard(s) into %s\n", len(quarantined), lostFound)
+			fmt.Printf("Recovered %d record(s) written since WAL checkpoint %d\n", recoveredCount, lastCheckpoint)
+			fmt.Println("Records from the quarantined shards written before that checkpoint could not be recovered and were dropped.")
+		},
+	})
+
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatalf("YarnDB command execution failed: %v", err)
 		os.Exit(1)
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrConflict is returned by Transaction.Commit when a key the transaction
+// read or wrote was mutated by another transaction at a newer version since
+// the snapshot was taken.
+var ErrConflict = errors.New("yarndb: transaction conflict")
+
+// versionRingSize bounds how many past revisions of a single record are
+// retained for snapshot reads. A long-lived Snapshot whose key gets
+// rewritten more than versionRingSize times falls back to the oldest
+// retained revision rather than the exact one (see recordVersionAt).
+const versionRingSize = 16
+
+// recordVersion is one retained revision of a record. data is nil when the
+// revision represents a delete.
+type recordVersion struct {
+	version uint64
+	data    interface{}
+}
+
+// Snapshot is a read-only, point-in-time view of the datastore pinned to
+// the version in effect when it was taken. Get/Query/Merge on a Snapshot
+// never block writers and never observe writes made after it was taken.
+type Snapshot struct {
+	ds          *YAMLDatastore
+	readVersion uint64
+}
+
+// GetSnapshot hands out a Snapshot pinned to the current version. Taking a
+// snapshot only needs a brief read lock; it never blocks writers.
+func (ds *YAMLDatastore) GetSnapshot() *Snapshot {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return &Snapshot{ds: ds, readVersion: ds.version}
+}
+
+// Get retrieves a record as of the snapshot's read version.
+func (s *Snapshot) Get(recordID string) (interface{}, error) {
+	s.ds.mu.RLock()
+	defer s.ds.mu.RUnlock()
+	data, ok := s.ds.recordVersionAt(recordID, s.readVersion)
+	if !ok {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// Query finds records matching a key=value condition as of the snapshot's
+// read version.
+func (s *Snapshot) Query(key, value string) (map[string]interface{}, error) {
+	s.ds.mu.RLock()
+	defer s.ds.mu.RUnlock()
+
+	result := make(map[string]interface{})
+	for id := range s.ds.knownRecordIDs() {
+		data, ok := s.ds.recordVersionAt(id, s.readVersion)
+		if !ok {
+			continue
+		}
+		if val, ok := getNestedValue(data, key); ok && fmt.Sprintf("%v", val) == value {
+			result[id] = data
+		}
+	}
+	return result, nil
+}
+
+// Merge combines every record visible as of the snapshot's read version.
+func (s *Snapshot) Merge() (map[string]interface{}, error) {
+	s.ds.mu.RLock()
+	defer s.ds.mu.RUnlock()
+
+	merged := make(map[string]interface{})
+	for id := range s.ds.knownRecordIDs() {
+		if data, ok := s.ds.recordVersionAt(id, s.readVersion); ok {
+			merged[id] = data
+		}
+	}
+	return merged, nil
+}
+
+// Transaction is an isolated read/write view with its own write buffer and
+// pinned read snapshot. Any number of transactions may be active at once;
+// Commit validates optimistically rather than taking a lock for the
+// transaction's whole lifetime.
+type Transaction struct {
+	ds       *YAMLDatastore
+	snapshot *Snapshot
+	writes   map[string]interface{} // recordID -> data, nil means delete
+	fileIDs  map[string]string      // recordID -> fileID, for sets only
+	reads    map[string]uint64      // recordID -> version observed at read time
+	wal      []walEntry
+	done     bool
+}
+
+// BeginTransaction starts a new transaction pinned to a fresh snapshot.
+func (ds *YAMLDatastore) BeginTransaction() (*Transaction, error) {
+	tx := &Transaction{
+		ds:       ds,
+		snapshot: ds.GetSnapshot(),
+		writes:   make(map[string]interface{}),
+		fileIDs:  make(map[string]string),
+		reads:    make(map[string]uint64),
+	}
+	log.Info("Transaction started")
+	return tx, nil
+}
+
+// Get reads a record through the transaction: its own buffered writes take
+// precedence, otherwise it sees the pinned snapshot. The key's current
+// version is recorded so Commit can detect a conflicting write by someone
+// else.
+func (tx *Transaction) Get(recordID string) (interface{}, error) {
+	if data, ok := tx.writes[recordID]; ok {
+		return data, nil
+	}
+	tx.ds.mu.RLock()
+	data, ok := tx.ds.recordVersionAt(recordID, tx.snapshot.readVersion)
+	tx.reads[recordID] = tx.ds.latestVersion(recordID)
+	tx.ds.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// Set buffers a record write in the transaction.
+func (tx *Transaction) Set(recordID string, data interface{}, fileID string) error {
+	payload, err := yaml.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("encoding record %s for WAL: %w", recordID, err)
+	}
+	tx.writes[recordID] = data
+	tx.fileIDs[recordID] = fileID
+	tx.wal = append(tx.wal, walEntry{op: walOpSet, recordID: recordID, fileID: fileID, payload: payload})
+	return nil
+}
+
+// Delete buffers a record delete in the transaction.
+func (tx *Transaction) Delete(recordID string) error {
+	tx.writes[recordID] = nil
+	tx.wal = append(tx.wal, walEntry{op: walOpDelete, recordID: recordID})
+	return nil
+}
+
+// Commit validates the transaction's read and write sets against the
+// current version of each key; if none have moved since the snapshot was
+// taken, it appends the WAL group and applies every buffered write
+// atomically under ds.mu. saveMu is held for the whole validate-append-apply
+// sequence, which both serializes commits against each other and against
+// Set/Delete so the WAL and in-memory state never diverge.
+func (tx *Transaction) Commit() error {
+	if tx.done {
+		return errors.New("transaction already finished")
+	}
+	tx.done = true
+
+	tx.ds.saveMu.Lock()
+	defer tx.ds.saveMu.Unlock()
+
+	if tx.ds.hasConflict(tx) {
+		return ErrConflict
+	}
+	if len(tx.wal) > 0 {
+		if _, err := tx.ds.wal.AppendGroup(tx.wal); err != nil {
+			return err
+		}
+	}
+
+	tx.ds.mu.Lock()
+	defer tx.ds.mu.Unlock()
+
+	newVersion := tx.ds.nextVersion()
+	for id, data := range tx.writes {
+		if data == nil {
+			tx.ds.dirtyFiles[tx.ds.shardPathFor(id)] = true
+			delete(tx.ds.data, id)
+			tx.ds.removeFromIndexes(id)
+		} else {
+			path := filepath.Join(tx.ds.dir, "records_"+tx.fileIDs[id]+".yaml")
+			tx.ds.data[id] = data
+			tx.ds.files[path] = true
+			tx.ds.dirtyFiles[path] = true
+			tx.ds.updateIndexes(id, data)
+		}
+		tx.ds.pushHistory(id, newVersion, data)
+	}
+	tx.ds.dirty = true
+	tx.ds.invalidateCache()
+	log.Info("Transaction committed")
+	return nil
+}
+
+// hasConflict reports whether any key tx read or wrote has been mutated at
+// a version newer than tx's snapshot.
+func (ds *YAMLDatastore) hasConflict(tx *Transaction) bool {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	for id := range tx.reads {
+		if ds.latestVersion(id) > tx.snapshot.readVersion {
+			return true
+		}
+	}
+	for id := range tx.writes {
+		if ds.latestVersion(id) > tx.snapshot.readVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// Rollback discards the transaction's buffered writes. Nothing is written
+// to the WAL or datastore until Commit, so this just marks it finished.
+func (tx *Transaction) Rollback() {
+	tx.done = true
+	log.Info("Transaction rolled back")
+}
+
+// knownRecordIDs returns every recordID the datastore currently holds or
+// has retained history for, so snapshot reads can still see records
+// deleted after the snapshot was taken. Callers must hold ds.mu.
+func (ds *YAMLDatastore) knownRecordIDs() map[string]struct{} {
+	ids := make(map[string]struct{}, len(ds.data))
+	for id := range ds.data {
+		ids[id] = struct{}{}
+	}
+	for id := range ds.history {
+		ids[id] = struct{}{}
+	}
+	return ids
+}
+
+// recordVersionAt returns the value of recordID as of atVersion by walking
+// its retained history newest-first. Callers must hold ds.mu (a read lock
+// is sufficient). If every retained revision is newer than atVersion
+// because the ring has evicted the one we want, the oldest retained
+// revision is returned as a best-effort approximation and a warning is
+// logged; a key never written since versioning began falls back to its
+// current value.
+func (ds *YAMLDatastore) recordVersionAt(recordID string, atVersion uint64) (interface{}, bool) {
+	hist := ds.history[recordID]
+	for i := len(hist) - 1; i >= 0; i-- {
+		if hist[i].version <= atVersion {
+			return hist[i].data, hist[i].data != nil
+		}
+	}
+	if len(hist) > 0 {
+		log.Warnf("mvcc: version history for %s evicted past snapshot version %d; returning oldest retained version", recordID, atVersion)
+		oldest := hist[0]
+		return oldest.data, oldest.data != nil
+	}
+	data, exists := ds.data[recordID]
+	return data, exists
+}
+
+// latestVersion returns the most recent version recorded for recordID, or 0
+// if it hasn't been written since the datastore started versioning.
+// Callers must hold ds.mu.
+func (ds *YAMLDatastore) latestVersion(recordID string) uint64 {
+	hist := ds.history[recordID]
+	if len(hist) == 0 {
+		return 0
+	}
+	return hist[len(hist)-1].version
+}
+
+// nextVersion reserves and returns the next global version number. Callers
+// must hold ds.mu (write lock).
+func (ds *YAMLDatastore) nextVersion() uint64 {
+	ds.version++
+	return ds.version
+}
+
+// pushHistory appends a new revision for recordID, evicting the oldest
+// retained revision once the ring exceeds versionRingSize. Callers must
+// hold ds.mu (write lock).
+func (ds *YAMLDatastore) pushHistory(recordID string, version uint64, data interface{}) {
+	hist := append(ds.history[recordID], recordVersion{version: version, data: data})
+	if len(hist) > versionRingSize {
+		hist = hist[len(hist)-versionRingSize:]
+	}
+	ds.history[recordID] = hist
+}
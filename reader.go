@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// lostFoundDirName is where the `repair` command quarantines corrupted
+// shards; ConcurrentRead skips it so repair output is never reloaded as
+// live data.
+const lostFoundDirName = "lost+found"
+
+// shardResult is one shard's outcome from the parallel read phase of
+// ConcurrentRead.
+type shardResult struct {
+	path    string
+	records map[string]interface{}
+}
+
+// ConcurrentRead loads all YAML files concurrently, merges them into
+// ds.data, then rebuilds every index's contents with one goroutine per
+// shard (each Index is internally synchronized, so concurrent Put calls
+// from different shards are safe) rather than updating indexes per record
+// under ds.mu.Lock, which used to serialize the whole "concurrent" read.
+func (ds *YAMLDatastore) ConcurrentRead() error {
+	// Collect YAML files
+	var files []string
+	err := filepath.Walk(ds.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == lostFoundDirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".yaml") && info.Name() != indexDefsFileName {
+			files = append(files, path)
+			ds.files[path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Preallocate data map
+	ds.mu.Lock()
+	ds.data = make(map[string]interface{}, len(files)*100)
+	ds.mu.Unlock()
+
+	// Read and parse shards concurrently, without touching ds.data or
+	// ds.indexes yet.
+	results := make([]shardResult, len(files))
+	var wg sync.WaitGroup
+	var corruptedMu sync.Mutex
+	start := time.Now()
+	for i, path := range files {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			records, err := ds.readFile(path)
+			if err != nil {
+				log.Errorf("Error reading file: %v", err)
+				if IsCorrupted(err) {
+					corruptedMu.Lock()
+					ds.corruptedFiles = append(ds.corruptedFiles, path)
+					corruptedMu.Unlock()
+				}
+				return
+			}
+			results[i] = shardResult{path: path, records: records}
+			atomic.AddUint64(&ds.loadCount, 1)
+		}(i, path)
+	}
+	wg.Wait()
+
+	// Merge every shard's records into ds.data in one pass, seeding each
+	// record's version history with the version in effect at load
+	// completion. Without this, a record loaded from disk has empty
+	// history, so the first time it's rewritten after a snapshot is taken,
+	// recordVersionAt finds nothing <= the snapshot's version and falls
+	// through to the ring-evicted fallback, returning the newest revision
+	// instead of the pre-snapshot one.
+	ds.mu.Lock()
+	loadVersion := ds.version
+	for _, res := range results {
+		for id, record := range res.records {
+			ds.data[id] = record
+			ds.pushHistory(id, loadVersion, record)
+		}
+	}
+	ds.mu.Unlock()
+
+	// Rebuild index contents, one goroutine per shard. ds.indexes itself
+	// isn't mutated here (only its Index values' internal state is), and no
+	// other goroutine touches it during startup, so ranging over it
+	// unlocked is safe.
+	var idxWg sync.WaitGroup
+	for _, res := range results {
+		if len(res.records) == 0 {
+			continue
+		}
+		idxWg.Add(1)
+		go func(records map[string]interface{}) {
+			defer idxWg.Done()
+			for id, record := range records {
+				for _, idx := range ds.indexes {
+					idx.Put(id, record)
+				}
+			}
+		}(res.records)
+	}
+	idxWg.Wait()
+
+	log.Infof("Loaded %d files in %v", atomic.LoadUint64(&ds.loadCount), time.Since(start))
+	return nil
+}
+
+// readFile reads, integrity-checks, and parses a single shard file,
+// returning its records. A header/CRC32 mismatch is returned as a
+// *CorruptedError (see IsCorrupted) rather than a plain error, so
+// ConcurrentRead can tell a torn write apart from an ordinary I/O or YAML
+// problem. It deliberately doesn't touch ds.data or ds.indexes itself, so
+// ConcurrentRead controls how much of the read serializes across shards.
+func (ds *YAMLDatastore) readFile(path string) (map[string]interface{}, error) {
+	body, err := validateShardFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileData map[string]interface{}
+	if err := yaml.Unmarshal(body, &fileData); err != nil {
+		return nil, err
+	}
+	log.Debugf("Loaded file %s with %d records", path, len(fileData))
+	return fileData, nil
+}
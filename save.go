@@ -6,12 +6,17 @@ import (
 	"syscall"
 )
 
-// handleSignals saves state on program termination
+// handleSignals saves state on program termination. If the TCP server is
+// running, it's shut down first so in-flight commands finish before Save
+// runs, rather than racing a half-handled SET against the final flush.
 func (ds *YAMLDatastore) handleSignals() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
 	log.Info("Received shutdown signal, saving state...")
+	if srv != nil {
+		srv.Shutdown()
+	}
 	if err := ds.Save(); err != nil {
 		log.Errorf("Final save error: %v", err)
 	}
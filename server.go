@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// readTimeout wraps time.Duration so read_timeout can be configured as a
+// plain string ("5s", "500ms") in config.yaml instead of raw nanoseconds.
+type readTimeout struct {
+	time.Duration
+}
+
+func (r *readTimeout) UnmarshalText(text []byte) error {
+	d, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid read_timeout %q: %w", string(text), err)
+	}
+	r.Duration = d
+	return nil
+}
+
+func readTimeoutFromConfig() time.Duration {
+	val := viper.GetString("read_timeout")
+	if val == "" {
+		val = "30s"
+	}
+	var rt readTimeout
+	if err := rt.UnmarshalText([]byte(val)); err != nil {
+		log.Warnf("%v, defaulting to 30s", err)
+		return 30 * time.Second
+	}
+	return rt.Duration
+}
+
+// deadlineConn pushes SetReadDeadline(now+timeout) ahead of every Read, so a
+// client that stops sending mid-command doesn't pin its handler goroutine
+// forever.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if err := c.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// subEvent is delivered to SUB subscribers whenever a SET (direct or
+// committed via a transaction) touches a record that has the subscribed key.
+type subEvent struct {
+	recordID string
+	data     interface{}
+}
+
+// Server exposes the datastore over TCP: a newline-terminated text protocol
+// for interactive/scripted use, and a length-prefixed binary protocol on a
+// second port for large YAML payloads.
+type Server struct {
+	ds          *YAMLDatastore
+	listenAddr  string
+	binAddr     string
+	readTimeout time.Duration
+
+	listener    net.Listener
+	binListener net.Listener
+	wg          sync.WaitGroup
+	quit        chan struct{}
+	quitOnce    sync.Once
+
+	subMu sync.Mutex
+	subs  map[string][]chan subEvent // key -> subscriber channels
+}
+
+// NewServer builds a Server from viper config (listen_addr, bin_listen_addr,
+// read_timeout).
+func NewServer(ds *YAMLDatastore) *Server {
+	return &Server{
+		ds:          ds,
+		listenAddr:  viper.GetString("listen_addr"),
+		binAddr:     viper.GetString("bin_listen_addr"),
+		readTimeout: readTimeoutFromConfig(),
+		quit:        make(chan struct{}),
+		subs:        make(map[string][]chan subEvent),
+	}
+}
+
+// subscribe registers a new subscriber channel for key.
+func (s *Server) subscribe(key string) chan subEvent {
+	ch := make(chan subEvent, 16)
+	s.subMu.Lock()
+	s.subs[key] = append(s.subs[key], ch)
+	s.subMu.Unlock()
+	return ch
+}
+
+// unsubscribe removes a subscriber channel registered by subscribe.
+func (s *Server) unsubscribe(key string, ch chan subEvent) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	chans := s.subs[key]
+	for i, c := range chans {
+		if c == ch {
+			s.subs[key] = append(chans[:i], chans[i+1:]...)
+			break
+		}
+	}
+}
+
+// publish notifies every subscriber whose key is present on data. Slow
+// subscribers are dropped rather than blocking the writer.
+func (s *Server) publish(recordID string, data interface{}) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	for key, chans := range s.subs {
+		if _, ok := getNestedValue(data, key); !ok {
+			continue
+		}
+		for _, ch := range chans {
+			select {
+			case ch <- subEvent{recordID: recordID, data: data}:
+			default:
+				log.Warnf("serve: dropping SUB event for slow subscriber on key %s", key)
+			}
+		}
+	}
+}
+
+// Start begins accepting connections on the text listener, and on the
+// binary listener too if bin_listen_addr is configured.
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return fmt.Errorf("serve: listen on %s: %w", s.listenAddr, err)
+	}
+	s.listener = ln
+	log.Infof("serve: line protocol listening on %s", s.listenAddr)
+	s.wg.Add(1)
+	go s.acceptLoop(ln, s.handleLineConn)
+
+	if s.binAddr != "" {
+		binLn, err := net.Listen("tcp", s.binAddr)
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("serve: listen on %s: %w", s.binAddr, err)
+		}
+		s.binListener = binLn
+		log.Infof("serve: binary protocol listening on %s", s.binAddr)
+		s.wg.Add(1)
+		go s.acceptLoop(binLn, s.handleBinaryConn)
+	}
+	return nil
+}
+
+func (s *Server) acceptLoop(ln net.Listener, handle func(net.Conn)) {
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+				log.Errorf("serve: accept error: %v", err)
+				continue
+			}
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			handle(conn)
+		}()
+	}
+}
+
+// Shutdown stops accepting new connections and blocks until every
+// in-flight command has drained, so a subsequent Save sees a quiescent
+// datastore.
+func (s *Server) Shutdown() {
+	s.quitOnce.Do(func() {
+		close(s.quit)
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		if s.binListener != nil {
+			s.binListener.Close()
+		}
+	})
+	s.wg.Wait()
+	log.Info("serve: shut down, all connections drained")
+}
+
+// handleLineConn serves the plain-text command protocol:
+//
+//	SET id yaml
+//	GET id
+//	DEL id
+//	QUERY key value
+//	BEGIN / COMMIT / ROLLBACK
+//	SUB key
+//
+// BEGIN opens a *Transaction scoped to this connection, so concurrent
+// clients never block each other the way the old single global transaction
+// did.
+func (s *Server) handleLineConn(conn net.Conn) {
+	defer conn.Close()
+	dc := &deadlineConn{Conn: conn, timeout: s.readTimeout}
+	scanner := bufio.NewScanner(dc)
+	scanner.Buffer(make([]byte, 4096), 1<<20)
+
+	var writeMu sync.Mutex
+	writeLine := func(line string) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, err := fmt.Fprintln(conn, line)
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	var tx *Transaction
+	var subKeys []string
+	var subChans []chan subEvent
+	defer func() {
+		for i, key := range subKeys {
+			s.unsubscribe(key, subChans[i])
+		}
+	}()
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if strings.ToUpper(parts[0]) == "SUB" {
+			if len(parts) != 2 || parts[1] == "" {
+				writeLine("ERR usage: SUB key")
+				continue
+			}
+			key := parts[1]
+			ch := s.subscribe(key)
+			subKeys = append(subKeys, key)
+			subChans = append(subChans, ch)
+			go forwardSubEvents(key, ch, done, writeLine)
+			writeLine("OK subscribed to " + key)
+			continue
+		}
+		reply := s.dispatchLine(line, &tx)
+		if err := writeLine(reply); err != nil {
+			log.Debugf("serve: write error: %v", err)
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Debugf("serve: connection closed: %v", err)
+	}
+}
+
+// forwardSubEvents relays events for a single SUB subscription to the
+// connection until it's unsubscribed (channel closed) or the connection
+// is done.
+func forwardSubEvents(key string, ch chan subEvent, done chan struct{}, writeLine func(string) error) {
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			out, err := yaml.Marshal(ev.data)
+			if err != nil {
+				continue
+			}
+			if writeLine(fmt.Sprintf("EVENT %s %s %s", key, ev.recordID, strings.TrimSpace(string(out)))) != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (s *Server) dispatchLine(line string, tx **Transaction) string {
+	parts := strings.SplitN(line, " ", 3)
+	cmd := strings.ToUpper(parts[0])
+
+	switch cmd {
+	case "SET":
+		if len(parts) != 3 {
+			return "ERR usage: SET id yaml"
+		}
+		var data interface{}
+		if err := yaml.Unmarshal([]byte(parts[2]), &data); err != nil {
+			return fmt.Sprintf("ERR invalid YAML: %v", err)
+		}
+		fileID := strings.SplitN(parts[1], "_", 2)[0]
+		if *tx != nil {
+			if err := (*tx).Set(parts[1], data, fileID); err != nil {
+				return fmt.Sprintf("ERR %v", err)
+			}
+			return "OK"
+		}
+		if err := s.ds.Set(parts[1], data, fileID); err != nil {
+			return fmt.Sprintf("ERR %v", err)
+		}
+		s.publish(parts[1], data)
+		return "OK"
+
+	case "GET":
+		if len(parts) != 2 {
+			return "ERR usage: GET id"
+		}
+		var (
+			data interface{}
+			err  error
+		)
+		if *tx != nil {
+			data, err = (*tx).Get(parts[1])
+		} else {
+			data, err = s.ds.Get(parts[1])
+		}
+		if err != nil {
+			return fmt.Sprintf("ERR %v", err)
+		}
+		if data == nil {
+			return "NIL"
+		}
+		out, err := yaml.Marshal(data)
+		if err != nil {
+			return fmt.Sprintf("ERR %v", err)
+		}
+		return "OK " + strings.TrimSpace(string(out))
+
+	case "DEL":
+		if len(parts) != 2 {
+			return "ERR usage: DEL id"
+		}
+		if *tx != nil {
+			if err := (*tx).Delete(parts[1]); err != nil {
+				return fmt.Sprintf("ERR %v", err)
+			}
+			return "OK"
+		}
+		if err := s.ds.Delete(parts[1]); err != nil {
+			return fmt.Sprintf("ERR %v", err)
+		}
+		return "OK"
+
+	case "QUERY":
+		if len(parts) != 3 {
+			return "ERR usage: QUERY key value | QUERY key1,key2 value1,value2 (composite)"
+		}
+		// A comma in the key names a composite index; keys and values line
+		// up positionally, same as query-composite's key=value arguments.
+		if strings.Contains(parts[1], ",") {
+			keys := strings.Split(parts[1], ",")
+			values := strings.Split(parts[2], ",")
+			if len(keys) != len(values) {
+				return "ERR usage: QUERY key1,key2 value1,value2 (same number of keys and values)"
+			}
+			records, err := s.ds.QueryComposite(keys, values)
+			if err != nil {
+				return fmt.Sprintf("ERR %v", err)
+			}
+			return fmt.Sprintf("OK %d matched", len(records))
+		}
+		records, err := s.ds.Query(parts[1], parts[2])
+		if err != nil {
+			return fmt.Sprintf("ERR %v", err)
+		}
+		return fmt.Sprintf("OK %d matched", len(records))
+
+	case "BEGIN":
+		if *tx != nil {
+			return "ERR transaction already open on this connection"
+		}
+		newTx, err := s.ds.BeginTransaction()
+		if err != nil {
+			return fmt.Sprintf("ERR %v", err)
+		}
+		*tx = newTx
+		return "OK"
+
+	case "COMMIT":
+		if *tx == nil {
+			return "ERR no transaction open"
+		}
+		committed := *tx
+		err := committed.Commit()
+		*tx = nil
+		if err != nil {
+			return fmt.Sprintf("ERR %v", err)
+		}
+		for id, data := range committed.writes {
+			if data != nil {
+				s.publish(id, data)
+			}
+		}
+		return "OK"
+
+	case "ROLLBACK":
+		if *tx == nil {
+			return "ERR no transaction open"
+		}
+		(*tx).Rollback()
+		*tx = nil
+		return "OK"
+
+	case "SUB":
+		return "ERR SUB must be issued as its own line: SUB key"
+
+	default:
+		return "ERR unknown command " + cmd
+	}
+}
+
+// Binary protocol: each request/response is a single frame of
+// [4-byte big-endian length][payload]. The payload's first byte is an
+// opcode (1=SET, 2=GET, 3=DEL); SET/GET/DEL are followed by a 4-byte
+// recordID length + recordID, then (SET only) a 4-byte fileID length +
+// fileID and a 4-byte YAML payload length + payload. This exists alongside
+// the line protocol so large records don't need textual escaping.
+const (
+	binOpSet byte = 1
+	binOpGet byte = 2
+	binOpDel byte = 3
+)
+
+func (s *Server) handleBinaryConn(conn net.Conn) {
+	defer conn.Close()
+	dc := &deadlineConn{Conn: conn, timeout: s.readTimeout}
+	r := bufio.NewReader(dc)
+
+	for {
+		req, err := readBinFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Debugf("serve: binary connection closed: %v", err)
+			}
+			return
+		}
+		resp := s.handleBinFrame(req)
+		if err := writeBinFrame(conn, resp); err != nil {
+			log.Debugf("serve: binary write error: %v", err)
+			return
+		}
+	}
+}
+
+func (s *Server) handleBinFrame(req []byte) []byte {
+	if len(req) < 1 {
+		return binErrorFrame("empty request")
+	}
+	switch req[0] {
+	case binOpSet:
+		recordID, rest, err := readBinString(req[1:])
+		if err != nil {
+			return binErrorFrame(err.Error())
+		}
+		fileID, rest, err := readBinString(rest)
+		if err != nil {
+			return binErrorFrame(err.Error())
+		}
+		payload, _, err := readBinString(rest)
+		if err != nil {
+			return binErrorFrame(err.Error())
+		}
+		var data interface{}
+		if err := yaml.Unmarshal([]byte(payload), &data); err != nil {
+			return binErrorFrame(fmt.Sprintf("invalid YAML: %v", err))
+		}
+		if err := s.ds.Set(recordID, data, fileID); err != nil {
+			return binErrorFrame(err.Error())
+		}
+		s.publish(recordID, data)
+		return []byte{0}
+
+	case binOpGet:
+		recordID, _, err := readBinString(req[1:])
+		if err != nil {
+			return binErrorFrame(err.Error())
+		}
+		data, err := s.ds.Get(recordID)
+		if err != nil {
+			return binErrorFrame(err.Error())
+		}
+		if data == nil {
+			return []byte{0}
+		}
+		out, err := yaml.Marshal(data)
+		if err != nil {
+			return binErrorFrame(err.Error())
+		}
+		return appendBinString([]byte{0}, out)
+
+	case binOpDel:
+		recordID, _, err := readBinString(req[1:])
+		if err != nil {
+			return binErrorFrame(err.Error())
+		}
+		if err := s.ds.Delete(recordID); err != nil {
+			return binErrorFrame(err.Error())
+		}
+		return []byte{0}
+
+	default:
+		return binErrorFrame(fmt.Sprintf("unknown opcode %d", req[0]))
+	}
+}
+
+func binErrorFrame(msg string) []byte {
+	return appendBinString([]byte{1}, []byte(msg))
+}
+
+func appendBinString(buf []byte, s []byte) []byte {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(s)))
+	buf = append(buf, lenBuf[:]...)
+	return append(buf, s...)
+}
+
+func readBinString(b []byte) (string, []byte, error) {
+	if len(b) < 4 {
+		return "", nil, fmt.Errorf("truncated length prefix")
+	}
+	n := binary.BigEndian.Uint32(b[:4])
+	b = b[4:]
+	if uint32(len(b)) < n {
+		return "", nil, fmt.Errorf("truncated field")
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+// maxBinFrameSize bounds a single binary-protocol frame's declared length.
+// The length prefix is read off the wire before any auth or size check on
+// the payload itself, so without a cap any connected client could claim a
+// ~4GB length and force a multi-gigabyte allocation per request; the line
+// protocol already caps its scanner buffer at 1<<20 for the same reason.
+const maxBinFrameSize = 64 << 20 // 64MiB, generous enough for "large" YAML payloads
+
+func readBinFrame(r *bufio.Reader) ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	if n > maxBinFrameSize {
+		return nil, fmt.Errorf("frame length %d exceeds max %d", n, maxBinFrameSize)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func writeBinFrame(w io.Writer, body []byte) error {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(body)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
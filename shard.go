@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// shardHeaderPattern matches the integrity header written at the top of
+// every shard file: "# yarndb v1 crc32=<hex> len=<bytes>".
+var shardHeaderPattern = regexp.MustCompile(`^# yarndb v1 crc32=([0-9a-fA-F]{1,8}) len=(\d+)\n$`)
+
+// shardHeader formats the integrity header for a shard whose YAML body is
+// body.
+func shardHeader(body []byte) string {
+	return fmt.Sprintf("# yarndb v1 crc32=%08x len=%d\n", crc32.ChecksumIEEE(body), len(body))
+}
+
+// parseShardHeader extracts the expected CRC32 and body length from a
+// shard file's first line.
+func parseShardHeader(line string) (crc uint32, length int, err error) {
+	m := shardHeaderPattern.FindStringSubmatch(line)
+	if m == nil {
+		return 0, 0, fmt.Errorf("malformed shard header %q", line)
+	}
+	crcVal, err := strconv.ParseUint(m[1], 16, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed crc32 in header: %w", err)
+	}
+	lenVal, err := strconv.Atoi(m[2])
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed length in header: %w", err)
+	}
+	return uint32(crcVal), lenVal, nil
+}
+
+// validateShardFile checks path's header against its body and, on success,
+// returns the body bytes. A mismatch (missing/malformed header, wrong
+// length, bad CRC32) is reported as a *CorruptedError so callers can tell
+// it apart from an ordinary I/O error via IsCorrupted.
+func validateShardFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	headerLine, err := r.ReadString('\n')
+	if err != nil {
+		return nil, &CorruptedError{Path: path, Err: fmt.Errorf("reading header: %w", err)}
+	}
+	crc, length, err := parseShardHeader(headerLine)
+	if err != nil {
+		return nil, &CorruptedError{Path: path, Err: err}
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &CorruptedError{Path: path, Err: fmt.Errorf("reading body: %w", err)}
+	}
+	if len(body) != length {
+		return nil, &CorruptedError{Path: path, Err: fmt.Errorf("length mismatch: header says %d, file has %d", length, len(body))}
+	}
+	if crc32.ChecksumIEEE(body) != crc {
+		return nil, &CorruptedError{Path: path, Err: fmt.Errorf("crc32 mismatch")}
+	}
+	return body, nil
+}
+
+// shardFileID recovers the fileID embedded in a shard's filename, e.g.
+// "records_abc123.yaml" -> "abc123".
+func shardFileID(path string) string {
+	base := filepath.Base(path)
+	base = strings.TrimSuffix(base, ".yaml")
+	return strings.TrimPrefix(base, "records_")
+}
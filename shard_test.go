@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// TestValidateShardFileDetectsCorruption covers both the happy path (a
+// well-formed header/body round-trips cleanly) and the failure this
+// request introduced integrity checking for: a shard whose body was
+// torn/corrupted after the header was written must be reported via
+// IsCorrupted, not silently skipped or partially parsed.
+func TestValidateShardFileDetectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	body, err := yaml.Marshal(map[string]interface{}{"rec1": map[string]interface{}{"val": "ok"}})
+	if err != nil {
+		t.Fatalf("marshal body: %v", err)
+	}
+
+	goodPath := filepath.Join(dir, "records_good.yaml")
+	if err := os.WriteFile(goodPath, []byte(shardHeader(body)+string(body)), 0644); err != nil {
+		t.Fatalf("write good shard: %v", err)
+	}
+	if _, err := validateShardFile(goodPath); err != nil {
+		t.Fatalf("validateShardFile rejected a well-formed shard: %v", err)
+	}
+
+	corruptPath := filepath.Join(dir, "records_bad.yaml")
+	corruptBody := append([]byte(nil), body...)
+	corruptBody[0] ^= 0xFF // flip a bit so crc32 no longer matches the header
+	if err := os.WriteFile(corruptPath, []byte(shardHeader(body)+string(corruptBody)), 0644); err != nil {
+		t.Fatalf("write corrupt shard: %v", err)
+	}
+	_, err = validateShardFile(corruptPath)
+	if err == nil {
+		t.Fatal("validateShardFile accepted a shard whose body doesn't match its header's crc32")
+	}
+	if !IsCorrupted(err) {
+		t.Fatalf("expected IsCorrupted(err) to be true, got: %v", err)
+	}
+}
+
+// TestNewYAMLDatastoreRefusesCorruptedShardsUnlessAllowed covers the
+// strict/non-strict startup gate: a corrupted shard must refuse to start
+// by default, and only proceed (with the corruption reported) when
+// allow_corrupted is set.
+func TestNewYAMLDatastoreRefusesCorruptedShardsUnlessAllowed(t *testing.T) {
+	dir := t.TempDir()
+	body, err := yaml.Marshal(map[string]interface{}{"rec1": map[string]interface{}{"val": "ok"}})
+	if err != nil {
+		t.Fatalf("marshal body: %v", err)
+	}
+	corruptBody := append([]byte(nil), body...)
+	corruptBody[0] ^= 0xFF
+	path := filepath.Join(dir, "records_bad.yaml")
+	if err := os.WriteFile(path, []byte(shardHeader(body)+string(corruptBody)), 0644); err != nil {
+		t.Fatalf("write corrupt shard: %v", err)
+	}
+
+	viper.Set("auto_save_interval", 3600)
+	t.Cleanup(func() { viper.Set("auto_save_interval", nil) })
+	t.Cleanup(func() { viper.Set("allow_corrupted", nil) })
+
+	viper.Set("allow_corrupted", false)
+	if _, err := NewYAMLDatastore(dir); err == nil || !strings.Contains(err.Error(), "corrupted") {
+		t.Fatalf("expected NewYAMLDatastore to refuse a corrupted shard by default, got err=%v", err)
+	}
+
+	viper.Set("allow_corrupted", true)
+	ds, err := NewYAMLDatastore(dir)
+	if err != nil {
+		t.Fatalf("NewYAMLDatastore with allow_corrupted=true: %v", err)
+	}
+	if len(ds.corruptedFiles) != 1 {
+		t.Fatalf("expected 1 corrupted file tracked, got %d: %v", len(ds.corruptedFiles), ds.corruptedFiles)
+	}
+}
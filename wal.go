@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// walOp identifies the kind of mutation a WAL record represents.
+type walOp byte
+
+const (
+	walOpSet walOp = iota + 1
+	walOpDelete
+	walOpCommit // sentinel closing a group; recordID/fileID/payload are unused
+)
+
+// walEntry is a single pending mutation to be appended to the WAL, either on
+// its own (a bare Set/Delete) or buffered as part of a transaction.
+type walEntry struct {
+	op       walOp
+	recordID string
+	fileID   string
+	payload  []byte // YAML-encoded record; nil for deletes
+}
+
+// walFrame is a decoded on-disk WAL record.
+type walFrame struct {
+	seq      uint64
+	op       walOp
+	recordID string
+	fileID   string
+	payload  []byte
+}
+
+// WAL is an append-only write-ahead log protecting the in-memory datastore
+// against losing mutations made between autoSave ticks. Every record is
+// framed with a length prefix and a CRC32 (IEEE) trailer so a torn write at
+// the tail (a crash mid-append) can be detected and discarded on replay.
+type WAL struct {
+	mu       sync.Mutex
+	dir      string
+	path     string
+	f        *os.File
+	nextSeq  uint64
+	syncMode string // always|interval|never, from wal_sync
+	stopCh   chan struct{}
+}
+
+func walPath(dir string) string        { return filepath.Join(dir, "wal.log") }
+func walCheckpointPath(dir string) string { return filepath.Join(dir, "wal.checkpoint") }
+
+// newWAL opens (or creates) the WAL file for dir and resumes sequence
+// numbering after the last checkpoint.
+func newWAL(dir string) (*WAL, error) {
+	f, err := os.OpenFile(walPath(dir), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("wal: open: %w", err)
+	}
+	syncMode := viper.GetString("wal_sync")
+	if syncMode == "" {
+		syncMode = "always"
+	}
+	w := &WAL{
+		dir:      dir,
+		path:     walPath(dir),
+		f:        f,
+		syncMode: syncMode,
+		stopCh:   make(chan struct{}),
+	}
+	// Sequence numbers are 1-indexed so that 0 unambiguously means "no
+	// checkpoint has ever been written", matching the zero-value
+	// lastCheckpoint callers pass when wal.checkpoint doesn't exist yet. If
+	// nextSeq started at 0, the very first group's seq (0) would be <=
+	// that same zero-value lastCheckpoint and committedWALFrames would
+	// discard it as already-applied, losing every write made before a
+	// datastore's first successful Save.
+	w.nextSeq = 1
+	if seq, err := readWALCheckpoint(dir); err == nil {
+		w.nextSeq = seq + 1
+	}
+	if w.syncMode == "interval" {
+		go w.runIntervalSync()
+	}
+	return w, nil
+}
+
+func (w *WAL) runIntervalSync() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if err := w.f.Sync(); err != nil {
+				log.Errorf("wal: interval fsync failed: %v", err)
+			}
+			w.mu.Unlock()
+		case <-w.stopCh:
+			return
+		}
+	}
+}
+
+// AppendGroup writes entries as one atomic framed group terminated by a
+// commit sentinel, and returns the group's sequence number. On replay, a
+// group is only applied once its commit sentinel has been read, so a crash
+// mid-transaction leaves the partial group ignored.
+func (w *WAL) AppendGroup(entries []walEntry) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seq := w.nextSeq
+	w.nextSeq++
+
+	var buf []byte
+	for _, e := range entries {
+		buf = appendWALFrame(buf, seq, e.op, e.recordID, e.fileID, e.payload)
+	}
+	buf = appendWALFrame(buf, seq, walOpCommit, "", "", nil)
+
+	if _, err := w.f.Write(buf); err != nil {
+		return 0, fmt.Errorf("wal: append: %w", err)
+	}
+	if w.syncMode == "always" {
+		if err := w.f.Sync(); err != nil {
+			return 0, fmt.Errorf("wal: fsync: %w", err)
+		}
+	}
+	return seq, nil
+}
+
+// LastSeq returns the most recently assigned group sequence number.
+func (w *WAL) LastSeq() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.nextSeq <= 1 {
+		return 0
+	}
+	return w.nextSeq - 1
+}
+
+// Checkpoint records seq as the highest applied sequence and truncates the
+// WAL, since every record up to and including seq is now reflected in the
+// on-disk snapshot written by Save.
+func (w *WAL) Checkpoint(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], seq)
+	tmp := walCheckpointPath(w.dir) + ".tmp"
+	if err := os.WriteFile(tmp, buf[:], 0644); err != nil {
+		return fmt.Errorf("wal: write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, walCheckpointPath(w.dir)); err != nil {
+		return fmt.Errorf("wal: rename checkpoint: %w", err)
+	}
+	if err := w.f.Truncate(0); err != nil {
+		return fmt.Errorf("wal: truncate: %w", err)
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("wal: seek: %w", err)
+	}
+	return nil
+}
+
+// Close stops the background syncer (if any) and closes the underlying file.
+func (w *WAL) Close() error {
+	close(w.stopCh)
+	return w.f.Close()
+}
+
+func readWALCheckpoint(dir string) (uint64, error) {
+	b, err := os.ReadFile(walCheckpointPath(dir))
+	if err != nil {
+		return 0, err
+	}
+	if len(b) < 8 {
+		return 0, fmt.Errorf("wal: malformed checkpoint file")
+	}
+	return binary.BigEndian.Uint64(b[:8]), nil
+}
+
+// replayWAL applies every committed group with seq > lastCheckpoint directly
+// into ds.data/ds.files/ds.indexes, and returns how many records were
+// replayed. Groups that never reached a commit sentinel (a crash mid
+// transaction) are discarded, as are any trailing frames that fail their
+// CRC check (a torn write).
+func (ds *YAMLDatastore) replayWAL(lastCheckpoint uint64) (int, error) {
+	frames, err := committedWALFrames(walPath(ds.dir), lastCheckpoint)
+	if err != nil {
+		return 0, fmt.Errorf("wal: replay: %w", err)
+	}
+
+	for _, fr := range frames {
+		ds.applyWALFrame(fr)
+	}
+	if len(frames) > 0 {
+		log.Infof("wal: replayed %d record(s) from %s", len(frames), walPath(ds.dir))
+	}
+	return len(frames), nil
+}
+
+// committedWALFrames decodes path and returns every entry frame belonging to
+// a group whose commit sentinel was observed, with seq > lastCheckpoint.
+// Groups that never reached a commit sentinel (a crash mid transaction) are
+// discarded. Shared by replayWAL and the `repair` command, which replays the
+// WAL against only the fileIDs affected by a quarantined shard.
+func committedWALFrames(path string, lastCheckpoint uint64) ([]walFrame, error) {
+	frames, err := readWALFrames(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[uint64][]walFrame)
+	var committed []walFrame
+	for _, fr := range frames {
+		if fr.seq <= lastCheckpoint {
+			continue
+		}
+		if fr.op == walOpCommit {
+			committed = append(committed, pending[fr.seq]...)
+			delete(pending, fr.seq)
+			continue
+		}
+		pending[fr.seq] = append(pending[fr.seq], fr)
+	}
+	return committed, nil
+}
+
+func (ds *YAMLDatastore) applyWALFrame(fr walFrame) {
+	switch fr.op {
+	case walOpSet:
+		var data interface{}
+		if err := yaml.Unmarshal(fr.payload, &data); err != nil {
+			log.Errorf("wal: skipping unreadable record %s: %v", fr.recordID, err)
+			return
+		}
+		ds.data[fr.recordID] = data
+		ds.files[filepath.Join(ds.dir, "records_"+fr.fileID+".yaml")] = true
+		ds.updateIndexes(fr.recordID, data)
+		ds.pushHistory(fr.recordID, ds.nextVersion(), data)
+	case walOpDelete:
+		delete(ds.data, fr.recordID)
+		ds.pushHistory(fr.recordID, ds.nextVersion(), nil)
+	}
+}
+
+// maxWALFrameBodySize bounds a single WAL frame's declared length. Replay
+// reads this length straight off a file that might be crash-corrupted, so
+// without a cap, garbage sitting where a length prefix belongs can claim
+// an enormous size and crash the process with an out-of-memory fatal
+// error during startup or `yarndb repair` -- exactly the kind of
+// corruption this feature exists to tolerate, not die on.
+const maxWALFrameBodySize = 64 << 20 // 64MiB
+
+// readWALFrames decodes every well-framed record in path. It stops (without
+// error) at the first short read, oversized length prefix, or CRC
+// mismatch, since a torn tail is the expected shape of a crash-interrupted
+// append.
+func readWALFrames(path string) ([]walFrame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var frames []walFrame
+	for {
+		var lenBuf [4]byte
+		if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+			break
+		}
+		n := binary.BigEndian.Uint32(lenBuf[:])
+		if n > maxWALFrameBodySize {
+			log.Warnf("wal: dropping crash-truncated record in %s: declared length %d exceeds max %d", path, n, maxWALFrameBodySize)
+			break
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil {
+			break
+		}
+		var crcBuf [4]byte
+		if _, err := io.ReadFull(r, crcBuf[:]); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(crcBuf[:]) {
+			log.Warnf("wal: dropping crash-truncated record in %s", path)
+			break
+		}
+		frame, err := decodeWALFrameBody(body)
+		if err != nil {
+			log.Warnf("wal: dropping malformed record in %s: %v", path, err)
+			break
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+func appendWALFrame(buf []byte, seq uint64, op walOp, recordID, fileID string, payload []byte) []byte {
+	body := encodeWALFrameBody(seq, op, recordID, fileID, payload)
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(body)))
+	buf = append(buf, lenBuf[:]...)
+	buf = append(buf, body...)
+	var crcBuf [4]byte
+	binary.BigEndian.PutUint32(crcBuf[:], crc32.ChecksumIEEE(body))
+	buf = append(buf, crcBuf[:]...)
+	return buf
+}
+
+func encodeWALFrameBody(seq uint64, op walOp, recordID, fileID string, payload []byte) []byte {
+	body := make([]byte, 0, 9+4+len(recordID)+4+len(fileID)+4+len(payload))
+	body = append(body, byte(op))
+	var seqBuf [8]byte
+	binary.BigEndian.PutUint64(seqBuf[:], seq)
+	body = append(body, seqBuf[:]...)
+	body = appendLenPrefixed(body, []byte(recordID))
+	body = appendLenPrefixed(body, []byte(fileID))
+	body = appendLenPrefixed(body, payload)
+	return body
+}
+
+func decodeWALFrameBody(body []byte) (walFrame, error) {
+	if len(body) < 9 {
+		return walFrame{}, fmt.Errorf("short record body")
+	}
+	op := walOp(body[0])
+	seq := binary.BigEndian.Uint64(body[1:9])
+	rest := body[9:]
+
+	recordID, rest, err := readLenPrefixedString(rest)
+	if err != nil {
+		return walFrame{}, err
+	}
+	fileID, rest, err := readLenPrefixedString(rest)
+	if err != nil {
+		return walFrame{}, err
+	}
+	payload, _, err := readLenPrefixed(rest)
+	if err != nil {
+		return walFrame{}, err
+	}
+	return walFrame{seq: seq, op: op, recordID: recordID, fileID: fileID, payload: payload}, nil
+}
+
+func appendLenPrefixed(buf []byte, b []byte) []byte {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(b)))
+	buf = append(buf, lenBuf[:]...)
+	return append(buf, b...)
+}
+
+func readLenPrefixedString(b []byte) (string, []byte, error) {
+	data, rest, err := readLenPrefixed(b)
+	return string(data), rest, err
+}
+
+func readLenPrefixed(b []byte) ([]byte, []byte, error) {
+	if len(b) < 4 {
+		return nil, nil, fmt.Errorf("truncated length prefix")
+	}
+	n := binary.BigEndian.Uint32(b[:4])
+	b = b[4:]
+	if uint32(len(b)) < n {
+		return nil, nil, fmt.Errorf("truncated payload")
+	}
+	return b[:n], b[n:], nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -8,7 +9,11 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-// Save writes the in-memory state to disk
+// Save writes every shard touched since the last Save back to disk. Records
+// are still grouped by shard every call (the grouping is cheap and the
+// shard a given fileID maps to can change between saves), but only shards
+// named in dirtyFiles are actually rewritten, since rewriting every shard on
+// every flush scales with total record count instead of with what changed.
 func (ds *YAMLDatastore) Save() error {
 	ds.saveMu.Lock()
 	defer ds.saveMu.Unlock()
@@ -26,6 +31,10 @@ func (ds *YAMLDatastore) Save() error {
 	for file := range ds.files {
 		filesCopy[file] = true
 	}
+	dirtyCopy := make(map[string]bool, len(ds.dirtyFiles))
+	for file := range ds.dirtyFiles {
+		dirtyCopy[file] = true
+	}
 	ds.mu.RUnlock()
 
 	// Group records by file
@@ -42,8 +51,12 @@ func (ds *YAMLDatastore) Save() error {
 		fileData[filePath][id] = data
 	}
 
-	// Write each file
-	for path, records := range fileData {
+	// Write only the shards that were actually touched since the last save
+	handled := make([]string, 0, len(dirtyCopy))
+	written := 0
+	for path := range dirtyCopy {
+		handled = append(handled, path)
+		records := fileData[path]
 		if len(records) == 0 {
 			continue
 		}
@@ -51,15 +64,24 @@ func (ds *YAMLDatastore) Save() error {
 		if err != nil {
 			return err
 		}
-		if err := os.WriteFile(path, out, 0644); err != nil {
+		framed := append([]byte(shardHeader(out)), out...)
+		if err := os.WriteFile(path, framed, 0644); err != nil {
 			return err
 		}
+		written++
 	}
 
 	ds.mu.Lock()
 	ds.dirty = false
+	for _, path := range handled {
+		delete(ds.dirtyFiles, path)
+	}
 	ds.mu.Unlock()
 
-	log.Info("Saved state to disk")
+	if err := ds.wal.Checkpoint(ds.wal.LastSeq()); err != nil {
+		return fmt.Errorf("checkpointing WAL: %w", err)
+	}
+
+	log.Infof("Saved state to disk (%d shard(s) rewritten)", written)
 	return nil
 }